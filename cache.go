@@ -0,0 +1,141 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ cache.go implements a small content-addressed build cache, the same
+ idea as cmd/go/internal/cache: every package's compiled object (and, for
+ libraries, its archive) is stored under a key derived from everything
+ that could affect its output, so an unrelated rebuild can be satisfied
+ by copying a cached artifact instead of re-running the compiler.
+*/
+package main
+
+import (
+	os "os";
+	"crypto/sha256";
+	"encoding/hex";
+	"encoding/json";
+	"fmt";
+	"io/ioutil";
+	"./godata";
+)
+
+const objectCacheName = "object";
+const archiveCacheName = "archive.a";
+const manifestCacheName = "manifest.json";
+
+type cacheManifest struct {
+	Name string;
+	BuildID string;
+}
+
+// cacheRoot returns $GOBUILD_CACHE, defaulting to $HOME/.cache/gobuild.
+func cacheRoot() string {
+	if dir := os.Getenv("GOBUILD_CACHE"); dir != "" {
+		return dir;
+	}
+	return os.Getenv("HOME") + "/.cache/gobuild";
+}
+
+func cacheEntryDir(id string) string {
+	return cacheRoot() + "/" + id[0:2] + "/" + id;
+}
+
+/*
+ buildID computes (and memoizes on pack.BuildID) the content hash that
+ identifies everything which can influence pack's compiled output: the
+ compiler binary's path and mtime, the concatenated contents of its
+ source files, the -I include paths, GOARCH, and the recursive build IDs
+ of every package it imports.
+*/
+func buildID(pack *godata.GoPackage) string {
+	if pack.BuildID != "" {
+		return pack.BuildID;
+	}
+
+	h := sha256.New();
+
+	compilerBin := toolchain.CompilerPath();
+	if dir, err := os.Stat(compilerBin); err == nil {
+		fmt.Fprintf(h, "compiler:%s:%d\n", compilerBin, dir.Mtime_ns);
+	}
+
+	for i := 0; i < pack.Files.Len(); i++ {
+		gf := pack.Files.At(i).(*godata.GoFile);
+		if contents, err := ioutil.ReadFile(gf.Filename); err == nil {
+			h.Write(contents);
+		}
+	}
+
+	fmt.Fprintf(h, "include:%s\n", *flagIncludePaths);
+	fmt.Fprintf(h, "goarch:%s\n", os.Getenv("GOARCH"));
+
+	for i := 0; i < pack.Depends.Len(); i++ {
+		dep := pack.Depends.At(i).(*godata.GoPackage);
+		fmt.Fprintf(h, "dep:%s:%s\n", dep.Name, buildID(dep));
+	}
+
+	pack.BuildID = hex.EncodeToString(h.Sum(nil));
+	return pack.BuildID;
+}
+
+func linkOrCopy(src, dst string) os.Error {
+	os.Remove(dst);
+	if err := os.Link(src, dst); err == nil {
+		return nil;
+	}
+	contents, err := ioutil.ReadFile(src);
+	if err != nil {
+		return err;
+	}
+	return ioutil.WriteFile(dst, contents, 0644);
+}
+
+// cacheFetch copies the cached file named entryName for this build ID
+// into dst, reporting whether a cache entry existed.
+func cacheFetch(id, entryName, dst string) bool {
+	src := cacheEntryDir(id) + "/" + entryName;
+	if _, err := os.Stat(src); err != nil {
+		return false;
+	}
+	if err := linkOrCopy(src, dst); err != nil {
+		return false;
+	}
+	return true;
+}
+
+// cacheStore saves src (a freshly produced build artifact) into the
+// cache under this build ID / entry name, alongside a small manifest.
+func cacheStore(pack *godata.GoPackage, id, entryName, src string) {
+	dir := cacheEntryDir(id);
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return;
+	}
+	linkOrCopy(src, dir+"/"+entryName);
+
+	manifest, err := json.Marshal(&cacheManifest{Name: pack.Name, BuildID: id});
+	if err == nil {
+		ioutil.WriteFile(dir+"/"+manifestCacheName, manifest, 0644);
+	}
+}
+
+// cacheFetchObject and cacheStoreObject handle the compiled .5/.6/.8
+// object produced by compile().
+func cacheFetchObject(pack *godata.GoPackage, dst string) bool {
+	return cacheFetch(buildID(pack), objectCacheName, dst);
+}
+
+func cacheStoreObject(pack *godata.GoPackage, src string) {
+	cacheStore(pack, buildID(pack), objectCacheName, src);
+}
+
+// cacheFetchArchive and cacheStoreArchive handle the .a archive produced
+// by packLib().
+func cacheFetchArchive(pack *godata.GoPackage, dst string) bool {
+	return cacheFetch(buildID(pack), archiveCacheName, dst);
+}
+
+func cacheStoreArchive(pack *godata.GoPackage, src string) {
+	cacheStore(pack, buildID(pack), archiveCacheName, src);
+}
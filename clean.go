@@ -0,0 +1,121 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ clean.go implements `gobuild -clean` by walking the tree with the same
+ path.Walk visitor pattern readFiles() uses, instead of shelling out to
+ "bash -c rm -rf", which doesn't exist on Windows or in a minimal
+ container. It also removes link/archive outputs placed under a custom
+ -o directory, tracked in a small manifest written by link()/packLib().
+*/
+package main
+
+import (
+	os "os";
+	"io/ioutil";
+	"path";
+	"strings";
+	"./logger";
+)
+
+const manifestFilename = ".gobuild-manifest";
+
+var objectFileExtensions = []string{".5", ".6", ".8", ".a"};
+
+func hasKnownObjectExtension(name string) bool {
+	for _, ext := range objectFileExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true;
+		}
+	}
+	if toolchain != nil && strings.HasSuffix(name, toolchain.ObjExt()) {
+		return true;
+	}
+	return false;
+}
+
+// recordOutput appends path to the manifest of build outputs, so a
+// later -clean can find it even if it was written outside rootPath (for
+// example under a custom -o directory).
+func recordOutput(outputPath string) {
+	f, err := os.Open(rootPath+"/"+manifestFilename, os.O_WRONLY|os.O_CREAT|os.O_APPEND, 0644);
+	if err != nil {
+		return;
+	}
+	defer f.Close();
+	f.WriteString(outputPath + "\n");
+}
+
+// readManifest returns every path recorded by recordOutput.
+func readManifest() []string {
+	contents, err := ioutil.ReadFile(rootPath + "/" + manifestFilename);
+	if err != nil {
+		return nil;
+	}
+	lines := strings.Split(string(contents), "\n");
+	var paths []string;
+	for _, line := range lines {
+		if line != "" {
+			paths = append(paths, line);
+		}
+	}
+	return paths;
+}
+
+// removeArtifact deletes artifactPath. A missing file is not an error -
+// the manifest and the tree walk can both name the same artifact.
+func removeArtifact(artifactPath string) {
+	err := os.Remove(artifactPath);
+	if err == nil {
+		logger.Debug("Removed %s\n", artifactPath);
+	}
+}
+
+// ========== cleanVisitor ==========
+
+// cleanVisitor walks the tree the same way goFileVisitor does, but
+// removes any file with a known compiled-object extension instead of
+// parsing .go files.
+type cleanVisitor struct{}
+
+func (v *cleanVisitor) VisitDir(dirPath string, d *os.Dir) bool {
+	if dirPath[strings.LastIndex(dirPath, "/")+1] == '.' {
+		return *flagIncludeInvisible;
+	}
+	return true;
+}
+
+func (v *cleanVisitor) VisitFile(filePath string, d *os.Dir) {
+	base := filePath[strings.LastIndex(filePath, "/")+1 : len(filePath)];
+	if base[0] == '.' && !*flagIncludeInvisible {
+		return;
+	}
+	if base == manifestFilename {
+		return;
+	}
+	if hasKnownObjectExtension(filePath) {
+		removeArtifact(filePath);
+	}
+}
+
+/*
+ clean deletes every compiled object/archive found while walking
+ rootPath, plus every link/archive output recorded in the manifest (this
+ is what lets it find artifacts placed in a custom -o path/ directory).
+*/
+func clean() {
+	errorChannel := make(chan os.Error, 64);
+	visitor := &cleanVisitor{};
+
+	path.Walk(rootPath, visitor, errorChannel);
+
+	if err, ok := <-errorChannel; ok {
+		logger.Error("Error while traversing directories: %s\n", err);
+	}
+
+	for _, outputPath := range readManifest() {
+		removeArtifact(outputPath);
+	}
+	os.Remove(rootPath + "/" + manifestFilename);
+}
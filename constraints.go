@@ -0,0 +1,89 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ constraints.go handles the filename half of Go's build constraints: the
+ "// +build" comment itself is evaluated per-file in godata.ParseFile,
+ but the _GOOS.go / _GOARCH.go / _GOOS_GOARCH.go filename suffix
+ convention can be checked before a file is even opened.
+*/
+package main
+
+import (
+	os "os";
+	"flag";
+	"runtime";
+	"strings";
+)
+
+var flagTags *string = flag.String("tags", "", "additional build tags, space separated");
+
+var knownGOOS = []string{"darwin", "freebsd", "linux", "netbsd", "openbsd", "plan9", "windows"};
+var knownGOARCH = []string{"386", "amd64", "arm"};
+
+func isKnownTag(list []string, tok string) bool {
+	for _, s := range list {
+		if s == tok {
+			return true;
+		}
+	}
+	return false;
+}
+
+// activeTags computes the set of build tags considered true for this
+// build: the host GOOS, the target GOARCH, a tag for the selected
+// toolchain, and whatever -tags adds.
+func activeTags() map[string]bool {
+	tags := map[string]bool{};
+
+	goarch := os.Getenv("GOARCH");
+	if goarch == "" {
+		goarch = runtime.GOARCH;
+	}
+	tags[runtime.GOOS] = true;
+	tags[goarch] = true;
+	tags[selectedCompiler()] = true;
+
+	for _, tag := range strings.Fields(*flagTags) {
+		tags[tag] = true;
+	}
+
+	return tags;
+}
+
+/*
+ matchesFileNameConstraints reports whether filename's optional
+ _GOOS.go, _GOARCH.go or _GOOS_GOARCH.go suffix (stripped of any _test
+ suffix first) matches tags. Files without a recognized suffix always
+ match.
+*/
+func matchesFileNameConstraints(filename string, tags map[string]bool) bool {
+	base := filename;
+	if idx := strings.LastIndex(base, "/"); idx != -1 {
+		base = base[idx+1 : len(base)];
+	}
+	if strings.HasSuffix(base, ".go") {
+		base = base[0 : len(base)-3];
+	}
+	if strings.HasSuffix(base, "_test") {
+		base = base[0 : len(base)-5];
+	}
+
+	parts := strings.Split(base, "_");
+	n := len(parts);
+
+	if n >= 2 {
+		goos, goarch := parts[n-2], parts[n-1];
+		if isKnownTag(knownGOOS, goos) && isKnownTag(knownGOARCH, goarch) {
+			return tags[goos] && tags[goarch];
+		}
+	}
+	if n >= 1 {
+		last := parts[n-1];
+		if isKnownTag(knownGOOS, last) || isKnownTag(knownGOARCH, last) {
+			return tags[last];
+		}
+	}
+	return true;
+}
@@ -0,0 +1,48 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing";
+
+func TestMatchesFileNameConstraintsNoSuffix(t *testing.T) {
+	tags := map[string]bool{"linux": true, "amd64": true};
+	if !matchesFileNameConstraints("foo.go", tags) {
+		t.Error("foo.go has no GOOS/GOARCH suffix and should always match");
+	}
+}
+
+func TestMatchesFileNameConstraintsSingleSuffix(t *testing.T) {
+	tags := map[string]bool{"linux": true, "amd64": true};
+	if !matchesFileNameConstraints("foo_linux.go", tags) {
+		t.Error("foo_linux.go should match when linux is active");
+	}
+	if matchesFileNameConstraints("foo_windows.go", tags) {
+		t.Error("foo_windows.go should not match when windows isn't active");
+	}
+}
+
+func TestMatchesFileNameConstraintsDoubleSuffix(t *testing.T) {
+	tags := map[string]bool{"linux": true, "amd64": true};
+	if !matchesFileNameConstraints("foo_linux_amd64.go", tags) {
+		t.Error("foo_linux_amd64.go should match when both GOOS and GOARCH are active");
+	}
+	if matchesFileNameConstraints("foo_linux_386.go", tags) {
+		t.Error("foo_linux_386.go should not match when GOARCH is amd64");
+	}
+}
+
+func TestMatchesFileNameConstraintsNoPrefix(t *testing.T) {
+	tags := map[string]bool{"linux": true, "amd64": true};
+	if !matchesFileNameConstraints("linux_amd64.go", tags) {
+		t.Error("linux_amd64.go (no package-name prefix) should still match");
+	}
+}
+
+func TestMatchesFileNameConstraintsTestSuffix(t *testing.T) {
+	tags := map[string]bool{"linux": true, "amd64": true};
+	if matchesFileNameConstraints("foo_windows_test.go", tags) {
+		t.Error("foo_windows_test.go should not match when windows isn't active");
+	}
+}
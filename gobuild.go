@@ -9,7 +9,6 @@ package main
 
 import (
 	os "os";
-	"exec";
 	"flag";
 	"path";
 	"strings";
@@ -21,7 +20,8 @@ import (
 
 var flagLibrary *bool = flag.Bool("lib", false, "build all packages as librarys");
 var flagBuildAll *bool = flag.Bool("a", false, "build all executables");
-var flagTesting *bool = flag.Bool("t", false, "(not yet implemented) Build all tests");
+var flagTesting *bool = flag.Bool("t", false, "build and run tests");
+var flagRun *string = flag.String("run", "", "run only tests matching this regexp");
 var flagSingleMainFile *bool = flag.Bool("single-main", false, "one main file per executable");
 var flagIncludeInvisible *bool = flag.Bool("include-hidden", false, "Include hidden directories");
 var flagOutputFileName *string = flag.String("o", "", "output file");
@@ -33,15 +33,10 @@ var flagClean *bool = flag.Bool("clean", false, "delete all temporary files");
 
 // ========== global (package) variables ==========
 
-var compilerBin string;
-var linkerBin string;
-var gopackBin string = "gopack";
-var compileError bool = false;
-var linkError bool = false;
 var rootPath string;
 var rootPathPerm int;
-var objExt string;
 var outputDirPrefix string;
+var buildTags map[string]bool;
 var goPackages *godata.GoPackageContainer;
 
 // ========== goFileVisitor ==========
@@ -70,7 +65,14 @@ func (v *goFileVisitor) VisitFile(path string, d *os.Dir) {
 			return;
 		}
 
-		gf := godata.GoFile{path[len(rootPath)+1:len(path)], nil, false, false};
+		// _GOOS.go / _GOARCH.go / _GOOS_GOARCH.go filename constraints
+		if !matchesFileNameConstraints(path, buildTags) {
+			logger.Debug("Skipping %s, GOOS/GOARCH filename doesn't match.\n", path);
+			return;
+		}
+
+		isTest := strings.HasSuffix(path, "_test.go");
+		gf := godata.GoFile{path[len(rootPath)+1:len(path)], nil, isTest, false, nil};
 		gf.ParseFile(goPackages);
 	}
 }
@@ -99,161 +101,119 @@ func readFiles(rootpath string) {
 }
 
 /*
- The compile method will run the compiler for every package it has found,
- starting with the main package.
+ The compile method runs the compiler for a single package. Its
+ dependencies are expected to already be compiled (and packed) by the
+ time this is called; the action graph built in schedule.go is what
+ guarantees that ordering now, rather than this function recursing into
+ pack.Depends itself.
 */
 func compile(pack *godata.GoPackage) {
-	var argv []string;
-	var argvFilled int;
-
-	// check for recursive dependencies
-	if pack.InProgress {
-		logger.Error("Found a recurisve dependency in %s. This is not supported in Go, aborting compilation.\n", pack.Name);
-		os.Exit(1);
-	}
-	pack.InProgress = true;
-
-	// first compile all dependencies
-	pack.Depends.Do(func(e interface{}) {
-		dep := e.(*godata.GoPackage);
-		if !dep.Compiled {
-			compile(dep);
-		}
-	});
-
 	// check if this package has any files (if not -> error)
 	if pack.Files.Len() == 0 {
 		logger.Error("No files found for package %s.\n", pack.Name);
 		os.Exit(1);
 	}
-	
-	// construct compiler command line arguments
+
+	// a cached object for this exact build ID means we don't have to
+	// spawn the compiler at all
+	objectFile := outputDirPrefix + pack.OutputFile + toolchain.ObjExt();
+	if cacheFetchObject(pack, objectFile) {
+		logger.Info("Using cached object for %s (%s).\n", pack.Name, buildID(pack));
+		pack.Compiled = true;
+		return;
+	}
+
 	if (pack.Name != "main") {
 		logger.Info("Compiling %s...\n", pack.Name);
 	} else {
 		logger.Info("Compiling %s (%s)...\n", pack.Name, pack.OutputFile);
 	}
-	if *flagIncludePaths != "" {
-		argv = make([]string, pack.Files.Len() + 5);
-	} else {
-		argv = make([]string, pack.Files.Len() + 3);
-	}
-
-	argv[argvFilled] = compilerBin; argvFilled++;
-	argv[argvFilled] = "-o"; argvFilled++;
-	argv[argvFilled] = outputDirPrefix + pack.OutputFile + objExt; argvFilled++;
-
-	if *flagIncludePaths != "" {
-		argv[argvFilled] = "-I"; argvFilled++;
-		argv[argvFilled] = *flagIncludePaths; argvFilled++;
-	}
 
 	logger.Info("\tfiles: ");
-	for i := 0; i < pack.Files.Len(); i++  {
-		gf := pack.Files.At(i).(*godata.GoFile);
-		argv[argvFilled] = gf.Filename;
-		logger.Info("%s ", argv[argvFilled]);
-		argvFilled++;
+	for i := 0; i < pack.Files.Len(); i++ {
+		logger.Info("%s ", pack.Files.At(i).(*godata.GoFile).Filename);
 	}
 	logger.Info("\n");
-		
-	cmd, err := exec.Run(compilerBin, argv[0:argvFilled], os.Environ(), exec.DevNull, 
-		exec.PassThrough, exec.PassThrough);
-	if err != nil {
-		logger.Error("%s\n", err);
-		os.Exit(1);
-	}
 
-	waitmsg, err := cmd.Wait(0);
-	if err != nil {
-		logger.Error("Compiler execution error (%s), aborting compilation.\n", err);
-		os.Exit(1);
+	var incl []string;
+	if *flagIncludePaths != "" {
+		incl = []string{*flagIncludePaths};
 	}
 
-	if waitmsg.ExitStatus() != 0 {
-		compileError = true;
+	if err := toolchain.Compile(pack, objectFile, incl); err != nil {
+		logger.Error("%s\n", err);
 		pack.HasErrors = true;
+	} else {
+		cacheStoreObject(pack, objectFile);
 	}
-	
+
 	// it should now be compiled
 	pack.Compiled = true;
-	pack.InProgress = false;
-
 }
 
 /*
  Calls the linker for the main file, which should be called "main.(5|6|8)".
+ Every local import pack transitively depends on was already packed into
+ a .a archive by packLib via the action graph; the gc linker finds those
+ through its -L search path even when only handed the main's own object
+ file, but gccgo has no such fallback and needs every dependency archive
+ named explicitly on the link line, so they're always passed through.
 */
-func link(pack *godata.GoPackage) {
-	var argv []string;
+func link(pack *godata.GoPackage) os.Error {
+	out := outputDirPrefix + pack.OutputFile;
+	objectFile := out + toolchain.ObjExt();
 
-	if *flagIncludePaths != "" {
-		argv = make([]string, 6);
-		argv = []string{
-			linkerBin,
-			"-o",
-			outputDirPrefix + pack.OutputFile,
-			"-L",
-			*flagIncludePaths,
-			outputDirPrefix + pack.OutputFile + objExt};
-		
-	} else {
-		argv = make([]string, 4);
-		argv = []string{
-			linkerBin,
-			"-o",
-			outputDirPrefix + pack.OutputFile,
-			outputDirPrefix + pack.OutputFile + objExt};
+	libs := []string{objectFile};
+	libs = append(libs, dependencyArchives(pack, map[*godata.GoPackage]bool{})...);
 
-	}
-	
-	logger.Info("Linking %s...\n", argv[2]);
+	logger.Info("Linking %s...\n", out);
 
-	cmd, err := exec.Run(linkerBin, argv, os.Environ(),
-		exec.DevNull, exec.PassThrough, exec.PassThrough);
-	if err != nil {
-		logger.Error("%s\n", err);
-		os.Exit(1);
-	}
-	waitmsg, err := cmd.Wait(0);
-	if err != nil {
-		logger.Error("Linker execution error (%s), aborting compilation.\n", err);
-		os.Exit(1);
+	if err := toolchain.Link(pack, out, libs); err != nil {
+		return err;
 	}
+	recordOutput(out);
+	return nil;
+}
 
-	if waitmsg.ExitStatus() != 0 {
-		logger.Error("Linker returned with errors, aborting.\n");
-		os.Exit(1);
+// dependencyArchives returns the .a archive path packLib produced for
+// every package pack transitively depends on, each listed exactly once.
+func dependencyArchives(pack *godata.GoPackage, seen map[*godata.GoPackage]bool) []string {
+	var archives []string;
+	for i := 0; i < pack.Depends.Len(); i++ {
+		dep := pack.Depends.At(i).(*godata.GoPackage);
+		if seen[dep] {
+			continue;
+		}
+		seen[dep] = true;
+		archives = append(archives, dependencyArchives(dep, seen)...);
+		archives = append(archives, outputDirPrefix+dep.OutputFile+".a");
 	}
+	return archives;
 }
 
-func packLib(pack *godata.GoPackage) {
+func packLib(pack *godata.GoPackage) os.Error {
+	// pack.OutputFile, not pack.Name, because that's what compile() used
+	// for the object file it already produced; they're the same string
+	// for an ordinary library package, but not for a "main" package under
+	// test (see runPackageTests), whose Name is always literally "main".
+	archiveFile := outputDirPrefix + pack.OutputFile + ".a";
 
-	logger.Info("Creating %s.a...\n", pack.Name);
-
-	argv := []string{
-		gopackBin,
-		"crg", // create new go archive
-		outputDirPrefix + pack.Name + ".a",
-		outputDirPrefix + pack.Name + objExt};
-
-	cmd, err := exec.Run(gopackBin, argv, os.Environ(),
-		exec.DevNull, exec.PassThrough, exec.PassThrough);
-	if err != nil {
-		logger.Error("%s\n", err);
-		os.Exit(1);
-	}
-	waitmsg, err := cmd.Wait(0);
-	if err != nil {
-		logger.Error("gopack execution error (%s), aborting.\n", err);
-		os.Exit(1);
+	if cacheFetchArchive(pack, archiveFile) {
+		logger.Info("Using cached archive for %s (%s).\n", pack.Name, buildID(pack));
+		recordOutput(archiveFile);
+		return nil;
 	}
 
-	if waitmsg.ExitStatus() != 0 {
-		logger.Error("gopack returned with errors, aborting.\n");
-		os.Exit(1);
+	logger.Info("Creating %s.a...\n", pack.Name);
+
+	objectFile := outputDirPrefix + pack.OutputFile + toolchain.ObjExt();
+	if err := toolchain.Pack(pack, archiveFile, objectFile); err != nil {
+		return err;
 	}
 
+	cacheStoreArchive(pack, archiveFile);
+	recordOutput(archiveFile);
+	return nil;
 }
 
 /*
@@ -277,7 +237,7 @@ func buildExecutable() {
 		os.Exit(1);
 	}
 	
-	// compile all needed packages
+	// build (compile + link) every main that was asked for
 	if flag.NArg() > 0 {
 		for _, fn := range flag.Args() {
 			mainPack, exists := goPackages.GetMain(fn, !*flagSingleMainFile);
@@ -286,29 +246,13 @@ func buildExecutable() {
 				return; // or os.Exit?
 			}
 
-			compile(mainPack);
-
-			// link everything together
-			if !compileError {
-				link(mainPack);
-			} else {
-				logger.Error("Can't link executable because of compile errors.\n");
-			}
+			buildMain(mainPack);
 		}
 	} else {
 		for _, mainPack := range goPackages.GetMainPackages(!*flagSingleMainFile) {
-
-			compile(mainPack);
-
-			// link everything together
-			if !compileError {
-				link(mainPack);
-			} else {
-				logger.Error("Can't link executable because of compile errors.\n");
-			}
+			buildMain(mainPack);
 		}
 	}
-	
 
 }
 
@@ -356,8 +300,7 @@ func buildLibrary() {
 
 		if !pack.Compiled {
 			logger.Debug("Building %s...\n", pack.Name);
-			compile(pack);
-			packLib(pack);
+			buildPackage(pack);
 		}
 	}
 
@@ -366,42 +309,7 @@ func buildLibrary() {
 /*
  This function does exactly the same as "make clean".
 */
-func clean() {
-	bashBin, err := exec.LookPath("bash");
-	if err != nil {
-		logger.Error("Need bash to clean.\n");
-		os.Exit(1);
-	}
-
-	argv := []string{bashBin, "-c", "commandhere"};
-
-	if *flagVerboseMode {
-		argv[2] = "rm -rfv *.[568]";
-	} else {
-		argv[2] = "rm -rf *.[568]";
-	}
-	
-	logger.Info("Running: %v\n", argv[2:]);
-
-	cmd, err := exec.Run(bashBin, argv, os.Environ(),
-		exec.DevNull, exec.PassThrough, exec.PassThrough);
-	if err != nil {
-		logger.Error("%s\n", err);
-		os.Exit(1);
-	}
-	waitmsg, err := cmd.Wait(0);
-	if err != nil {
-		logger.Error("Couldn't delete files: %s\n", err);
-		os.Exit(1);
-	}
-
-	if waitmsg.ExitStatus() != 0 {
-		logger.Error("rm returned with errors.\n");
-		os.Exit(1);
-	}
-
-
-}
+// clean() lives in clean.go.
 
 
 // Returns the bigger number.
@@ -427,47 +335,6 @@ func main() {
 		logger.SetVerbosityLevel(logger.DEBUG);
 	}
 
-	if *flagClean {
-		clean();
-		os.Exit(0);
-	}
-	
-	// get the compiler/linker executable
-	switch os.Getenv("GOARCH") {
-	case "amd64":
-		compilerBin = "6g";
-		linkerBin = "6l";
-		objExt = ".6";
-	case "386":
-		compilerBin = "8g";
-		linkerBin = "8l";
-		objExt = ".8";
-	case "arm":
-		compilerBin = "5g";
-		linkerBin = "5l";
-		objExt = ".5";
-	default:
-		logger.Error("Please specify a valid GOARCH (amd64/386/arm).\n");
-		os.Exit(1);		
-	}
-
-	// get the complete path to the compiler/linker
-	compilerBin, err = exec.LookPath(compilerBin);
-	if err != nil {
-		logger.Error("Could not find compiler %s: %s\n", compilerBin, err);
-		os.Exit(1);
-	}
-	linkerBin, err = exec.LookPath(linkerBin);
-	if err != nil {
-		logger.Error("Could not find linker %s: %s\n", linkerBin, err);
-		os.Exit(1);
-	}
-	gopackBin, err = exec.LookPath(gopackBin);
-	if err != nil {
-		logger.Error("Could not find gopack executable (%s): %s\n", gopackBin, err);
-		os.Exit(1);
-	}
-	
 	// get the root path from where the application was called
 	// and its permissions (used for subdirectories)
 	if rootPath, err = os.Getwd(); err != nil {
@@ -480,6 +347,23 @@ func main() {
 	}
 	rootPathPerm = rootPathDir.Permission();
 
+	// compute active build tags and hand them to godata for "// +build" evaluation
+	buildTags = activeTags();
+	godata.ActiveTags = buildTags;
+
+	// pick and initialize the toolchain (gc or gccgo); clean() needs this
+	// too, since it's what tells gccgo's ".o" objects apart from gc's.
+	toolchain, err = newToolchain();
+	if err != nil {
+		logger.Error("Could not set up toolchain: %s\n", err);
+		os.Exit(1);
+	}
+
+	if *flagClean {
+		clean();
+		os.Exit(0);
+	}
+
 	// create the package container
 	goPackages = godata.NewGoPackageContainer();
 
@@ -521,7 +405,11 @@ func main() {
 	// read all go files in the current path + subdirectories and parse them
 	readFiles(rootPath);
 
-	if *flagLibrary {
+	if *flagTesting {
+		if buildTests() {
+			os.Exit(1);
+		}
+	} else if *flagLibrary {
 		buildLibrary();
 	} else {
 		buildExecutable();
@@ -0,0 +1,323 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ godata - in-memory model of the go files and packages gobuild has
+ discovered while walking the source tree.
+*/
+package godata
+
+import (
+	"container/vector";
+	"go/ast";
+	"go/parser";
+	"strings";
+)
+
+// DefaultOutputFileName is used for the single main package case when
+// the user hasn't asked for a specific -o.
+var DefaultOutputFileName string = "a.out";
+
+// ActiveTags is the set of build tags considered satisfied while
+// parsing, e.g. GOOS, GOARCH and anything passed via -tags. gobuild sets
+// this once in main() before the first call to ParseFile.
+var ActiveTags map[string]bool = map[string]bool{};
+
+// ========== GoFile ==========
+
+// GoFile represents a single source file gobuild has found on disk.
+type GoFile struct {
+	Filename string;
+	File *ast.File;
+	IsTest bool;
+	Parsed bool;
+	TestFuncs []string;
+}
+
+/*
+ ParseFile parses the go file's AST, determines which package it belongs
+ to (creating the package in the container if this is the first file seen
+ for it) and records the local ("./...") imports as dependencies of that
+ package. For a _test.go file it also records the names of its
+ func Test*(t *testing.T) functions in TestFuncs, for the -t test-main
+ generator to enumerate.
+
+ A file whose leading "// +build" comment isn't satisfied by ActiveTags
+ is parsed but is not added to any package, the same way go/build
+ excludes it. A file that fails to parse is not added to any package
+ either, and the parse error is not reported.
+*/
+func (gf *GoFile) ParseFile(packages *GoPackageContainer) {
+	astFile, err := parser.ParseFile(gf.Filename, nil, nil, parser.ParseComments);
+	if err != nil {
+		return;
+	}
+	gf.File = astFile;
+	gf.Parsed = true;
+
+	if !satisfiesBuildComment(astFile, ActiveTags) {
+		return;
+	}
+
+	pkgName := astFile.Name.Name;
+
+	var pack *GoPackage;
+	if pkgName == "main" {
+		pack = packages.getOrCreateMain(gf.Filename);
+	} else {
+		pack = packages.getOrCreate(pkgName);
+	}
+	pack.Files.Push(gf);
+
+	for _, imp := range astFile.Imports {
+		path := strings.Trim(imp.Path.Value, "\"");
+		if !strings.HasPrefix(path, "./") {
+			continue;
+		}
+		depName := path[2:len(path)];
+		dep := packages.getOrCreate(depName);
+		pack.addDepend(dep);
+	}
+
+	if gf.IsTest {
+		for _, decl := range astFile.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && isTestFunc(fn) {
+				gf.TestFuncs = append(gf.TestFuncs, fn.Name.Name);
+			}
+		}
+	}
+}
+
+/*
+ satisfiesBuildComment looks at every comment group that appears before
+ the package clause and, for each "// +build ..." line found, checks
+ that it's satisfied by tags. Every such line must be satisfied (they're
+ ANDed together); within a line, space-separated terms are ORed and
+ comma-separated tags within a term are ANDed, with "!tag" negating it -
+ the same grammar go/build uses.
+*/
+func satisfiesBuildComment(astFile *ast.File, tags map[string]bool) bool {
+	for _, group := range astFile.Comments {
+		if group.Pos() >= astFile.Package {
+			continue;
+		}
+		for _, c := range group.List {
+			fields := strings.Fields(c.Text);
+			if len(fields) < 2 || fields[1] != "+build" {
+				continue;
+			}
+			if !satisfiesBuildLine(fields[2:len(fields)], tags) {
+				return false;
+			}
+		}
+	}
+	return true;
+}
+
+func satisfiesBuildLine(terms []string, tags map[string]bool) bool {
+	if len(terms) == 0 {
+		return true;
+	}
+	for _, term := range terms {
+		if satisfiesBuildTerm(term, tags) {
+			return true;
+		}
+	}
+	return false;
+}
+
+func satisfiesBuildTerm(term string, tags map[string]bool) bool {
+	for _, tag := range strings.Split(term, ",") {
+		negate := strings.HasPrefix(tag, "!");
+		if negate {
+			tag = tag[1:len(tag)];
+		}
+		if negate == tags[tag] {
+			return false;
+		}
+	}
+	return true;
+}
+
+// isTestFunc reports whether fn looks like a func Test*(t *testing.T),
+// the same shape `go test` looks for.
+func isTestFunc(fn *ast.FuncDecl) bool {
+	if fn.Recv != nil {
+		return false;
+	}
+	if !strings.HasPrefix(fn.Name.Name, "Test") {
+		return false;
+	}
+	if fn.Type.Params == nil || len(fn.Type.Params.List) != 1 {
+		return false;
+	}
+	star, ok := fn.Type.Params.List[0].Type.(*ast.StarExpr);
+	if !ok {
+		return false;
+	}
+	sel, ok := star.X.(*ast.SelectorExpr);
+	if !ok || sel.Sel.Name != "T" {
+		return false;
+	}
+	pkg, ok := sel.X.(*ast.Ident);
+	return ok && pkg.Name == "testing";
+}
+
+// ========== GoPackage ==========
+
+// GoPackage groups together all the files that make up one package (or,
+// for "main", one eventual executable) along with its build state.
+type GoPackage struct {
+	Name string;
+	OutputFile string;
+	Files *vector.Vector;
+	Depends *vector.Vector;
+	Compiled bool;
+	HasErrors bool;
+	BuildID string;
+}
+
+// NewMainPackage creates a synthetic "main" package (used by -t to build
+// a package's generated _testmain.go into a standalone test binary).
+func NewMainPackage(outputFile string) *GoPackage {
+	return newGoPackage("main", outputFile);
+}
+
+func newGoPackage(name, outputFile string) *GoPackage {
+	return &GoPackage{
+		Name: name,
+		OutputFile: outputFile,
+		Files: new(vector.Vector),
+		Depends: new(vector.Vector),
+	};
+}
+
+func (pack *GoPackage) addDepend(dep *GoPackage) {
+	for i := 0; i < pack.Depends.Len(); i++ {
+		if pack.Depends.At(i).(*GoPackage) == dep {
+			return;
+		}
+	}
+	pack.Depends.Push(dep);
+}
+
+// ========== GoPackageContainer ==========
+
+// GoPackageContainer is the set of all packages gobuild knows about,
+// keyed by package name, plus the set of discovered main files, keyed by
+// their would-be output file name.
+type GoPackageContainer struct {
+	packages map[string]*GoPackage;
+	mains map[string]*GoPackage;
+}
+
+func NewGoPackageContainer() *GoPackageContainer {
+	return &GoPackageContainer{
+		packages: make(map[string]*GoPackage),
+		mains: make(map[string]*GoPackage),
+	};
+}
+
+func (c *GoPackageContainer) getOrCreate(name string) *GoPackage {
+	pack, exists := c.packages[name];
+	if !exists {
+		pack = newGoPackage(name, name);
+		c.packages[name] = pack;
+	}
+	return pack;
+}
+
+func outputNameFor(filename string) string {
+	base := filename;
+	if idx := strings.LastIndex(base, "/"); idx != -1 {
+		base = base[idx+1 : len(base)];
+	}
+	if strings.HasSuffix(base, ".go") {
+		base = base[0 : len(base)-3];
+	}
+	return base;
+}
+
+func (c *GoPackageContainer) getOrCreateMain(filename string) *GoPackage {
+	out := outputNameFor(filename);
+	pack, exists := c.mains[out];
+	if !exists {
+		pack = newGoPackage("main", out);
+		c.mains[out] = pack;
+	}
+	return pack;
+}
+
+func (c *GoPackageContainer) Get(name string) (*GoPackage, bool) {
+	pack, exists := c.packages[name];
+	return pack, exists;
+}
+
+func (c *GoPackageContainer) GetPackageCount() int {
+	return len(c.packages);
+}
+
+func (c *GoPackageContainer) GetPackageNames() []string {
+	names := make([]string, len(c.packages));
+	i := 0;
+	for name := range c.packages {
+		names[i] = name;
+		i++;
+	}
+	return names;
+}
+
+func (c *GoPackageContainer) GetMainCount() int {
+	return len(c.mains);
+}
+
+func (c *GoPackageContainer) GetMainFilenames() []string {
+	names := make([]string, len(c.mains));
+	i := 0;
+	for name := range c.mains {
+		names[i] = name;
+		i++;
+	}
+	return names;
+}
+
+// GetMain looks up a single main by its output filename. When combine is
+// true and there's exactly one main overall, it is returned regardless
+// of the name asked for, mirroring how -single-main is opted out of.
+func (c *GoPackageContainer) GetMain(fn string, combine bool) (*GoPackage, bool) {
+	if pack, exists := c.mains[fn]; exists {
+		return pack, true;
+	}
+	if combine && len(c.mains) == 1 {
+		for _, pack := range c.mains {
+			return pack, true;
+		}
+	}
+	return nil, false;
+}
+
+// GetMainPackages returns every discovered main package. When combine is
+// true, all of them are merged into a single executable instead.
+func (c *GoPackageContainer) GetMainPackages(combine bool) []*GoPackage {
+	if !combine || len(c.mains) <= 1 {
+		packs := make([]*GoPackage, len(c.mains));
+		i := 0;
+		for _, pack := range c.mains {
+			packs[i] = pack;
+			i++;
+		}
+		return packs;
+	}
+
+	combined := newGoPackage("main", DefaultOutputFileName);
+	for _, pack := range c.mains {
+		for i := 0; i < pack.Files.Len(); i++ {
+			combined.Files.Push(pack.Files.At(i));
+		}
+		for i := 0; i < pack.Depends.Len(); i++ {
+			combined.addDepend(pack.Depends.At(i).(*GoPackage));
+		}
+	}
+	return []*GoPackage{combined};
+}
@@ -0,0 +1,41 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package godata
+
+import "testing";
+
+func TestSatisfiesBuildTerm(t *testing.T) {
+	tags := map[string]bool{"linux": true, "windows": false};
+
+	if !satisfiesBuildTerm("linux", tags) {
+		t.Error("linux should satisfy an active tag");
+	}
+	if satisfiesBuildTerm("windows", tags) {
+		t.Error("windows should not satisfy an inactive tag");
+	}
+	if !satisfiesBuildTerm("!windows", tags) {
+		t.Error("!windows should satisfy when windows isn't active");
+	}
+	if !satisfiesBuildTerm("linux,!windows", tags) {
+		t.Error("linux,!windows should satisfy when both comma-separated tags hold");
+	}
+	if satisfiesBuildTerm("linux,windows", tags) {
+		t.Error("linux,windows should fail because windows isn't active");
+	}
+}
+
+func TestSatisfiesBuildLine(t *testing.T) {
+	tags := map[string]bool{"linux": true};
+
+	if !satisfiesBuildLine([]string{}, tags) {
+		t.Error("a line with no terms should always be satisfied");
+	}
+	if !satisfiesBuildLine([]string{"windows", "linux"}, tags) {
+		t.Error("space-separated terms are ORed, so linux alone should satisfy the line");
+	}
+	if satisfiesBuildLine([]string{"windows", "darwin"}, tags) {
+		t.Error("neither windows nor darwin is active, so the line should fail");
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ logger - tiny leveled logger used to print build progress and
+ diagnostics to stderr.
+*/
+package logger
+
+import (
+	fmt "fmt";
+	os "os";
+)
+
+// verbosity levels, from least to most chatty
+const (
+	ERROR = iota;
+	WARN;
+	INFO;
+	DEBUG;
+)
+
+var verbosity int = INFO;
+
+// SetVerbosityLevel changes which levels are actually printed.
+func SetVerbosityLevel(level int) {
+	verbosity = level;
+}
+
+func log(level int, format string, v ...interface{}) {
+	if level > verbosity {
+		return;
+	}
+	fmt.Fprintf(os.Stderr, format, v...);
+}
+
+// Error prints a message regardless of verbosity and is always shown.
+func Error(format string, v ...interface{}) {
+	log(ERROR, format, v...);
+}
+
+// ErrorContinue prints a follow-up line to a previous Error call without
+// repeating a prefix.
+func ErrorContinue(format string, v ...interface{}) {
+	log(ERROR, format, v...);
+}
+
+// Warn prints a message when the verbosity level is WARN or higher.
+func Warn(format string, v ...interface{}) {
+	log(WARN, format, v...);
+}
+
+// Info prints a message when the verbosity level is INFO or higher.
+func Info(format string, v ...interface{}) {
+	log(INFO, format, v...);
+}
+
+// Debug prints a message only in -v (DEBUG) mode.
+func Debug(format string, v ...interface{}) {
+	log(DEBUG, format, v...);
+}
@@ -0,0 +1,273 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ schedule.go builds a dependency action graph out of the packages found
+ by readFiles() and runs it with a bounded worker pool, the same way
+ cmd/go/internal/work's Builder.Do schedules a build.
+*/
+package main
+
+import (
+	os "os";
+	"flag";
+	"runtime";
+	"sync";
+	"./godata";
+	"./logger";
+)
+
+// ========== command line parameters ==========
+
+var flagJobs *int = flag.Int("p", 0, "number of parallel build jobs (0 = NumCPU)");
+
+// ========== action ==========
+
+type actionKind int;
+
+const (
+	actionCompile actionKind = iota;
+	actionPack;
+	actionLink;
+)
+
+/*
+ An action is one node in the build graph: compiling a package, packing
+ its archive, or linking a main into an executable. Its Failed/Skipped
+ status lives on the action itself instead of a global flag, so an error
+ in one main's dependency graph can't bleed into an unrelated main when
+ -a builds several of them.
+*/
+type action struct {
+	kind actionKind;
+	pack *godata.GoPackage;
+	deps []*action;
+	waiters []*action;
+
+	mu sync.Mutex;
+	remaining int;
+	finished bool;
+	Failed bool;
+	Skipped bool;
+	done chan bool;
+}
+
+func newAction(kind actionKind, pack *godata.GoPackage) *action {
+	return &action{kind: kind, pack: pack, done: make(chan bool)};
+}
+
+/*
+ addDep records dep as a prerequisite of a. packActionFor memoizes pack
+ actions across separate buildMain/buildPackage calls (so a dependency
+ shared by two mains under -a is only built once), so by the time a
+ second graph adds a dependency edge to it, dep may already be finished.
+ In that case it's already satisfied: don't register a's waiter (dep
+ will never run() again to fire it) and don't count it against
+ a.remaining.
+*/
+func (a *action) addDep(dep *action) {
+	a.deps = append(a.deps, dep);
+
+	dep.mu.Lock();
+	alreadyDone := dep.finished;
+	if !alreadyDone {
+		dep.waiters = append(dep.waiters, a);
+	}
+	dep.mu.Unlock();
+
+	if !alreadyDone {
+		a.remaining++;
+	}
+}
+
+// run executes this action's actual work (compile/pack/link), unless an
+// upstream dependency already failed or was skipped, in which case this
+// action is skipped too rather than run. A shared action reachable from
+// more than one build graph (see addDep) can be handed to runGraph's
+// scheduleReady loop a second time after it has already finished; the
+// guard below makes that a no-op instead of re-running the action and
+// panicking on an already-closed done channel.
+func (a *action) run() {
+	a.mu.Lock();
+	if a.finished {
+		a.mu.Unlock();
+		return;
+	}
+	a.mu.Unlock();
+
+	for _, dep := range a.deps {
+		if dep.Failed || dep.Skipped {
+			a.Skipped = true;
+			break;
+		}
+	}
+
+	if a.Skipped {
+		// A pack/link action's only dependency is the compile action for
+		// its own package, so dep.Failed above already covers
+		// a.pack.HasErrors; surface the diagnostic here instead of in a
+		// kind-specific branch below that this skip never reaches.
+		if a.kind == actionLink {
+			logger.Error("Can't link executable because of compile errors.\n");
+		}
+	} else {
+		switch a.kind {
+		case actionCompile:
+			if !a.pack.Compiled {
+				compile(a.pack);
+			}
+			a.Failed = a.pack.HasErrors;
+		case actionPack:
+			if err := packLib(a.pack); err != nil {
+				logger.Error("%s\n", err);
+				a.Failed = true;
+			}
+		case actionLink:
+			if err := link(a.pack); err != nil {
+				logger.Error("%s\n", err);
+				a.Failed = true;
+			}
+		}
+	}
+
+	a.mu.Lock();
+	a.finished = true;
+	a.mu.Unlock();
+	close(a.done);
+
+	for _, w := range a.waiters {
+		w.mu.Lock();
+		w.remaining--;
+		ready := w.remaining == 0;
+		w.mu.Unlock();
+		if ready {
+			scheduleReady <- w;
+		}
+	}
+}
+
+// scheduleReady is the queue of actions whose dependencies have all
+// finished and which are ready to be picked up by a worker.
+var scheduleReady chan *action;
+
+func numJobs() int {
+	if *flagJobs > 0 {
+		return *flagJobs;
+	}
+	return runtime.NumCPU();
+}
+
+// packActions memoizes the pack (archive) action for each package across
+// the whole run, so that building several mains with -a only compiles
+// and packs a shared dependency once.
+var packActions = map[*godata.GoPackage]*action{};
+
+/*
+ buildGraph walks pack.Depends (memoizing completed actions in seen) and
+ returns the compile action for pack: every import gets a
+ compile-then-pack action pair, and pack's compile action depends on all
+ of its imports' pack actions. visiting detects import cycles the same
+ way the old recursive compile()'s InProgress flag did.
+*/
+func buildGraph(pack *godata.GoPackage, seen map[*godata.GoPackage]*action, visiting map[*godata.GoPackage]bool) *action {
+	if a, ok := seen[pack]; ok {
+		return a;
+	}
+	if visiting[pack] {
+		logger.Error("Found a recursive dependency in %s. This is not supported in Go, aborting compilation.\n", pack.Name);
+		os.Exit(1);
+	}
+	visiting[pack] = true;
+
+	compileAction := newAction(actionCompile, pack);
+
+	for i := 0; i < pack.Depends.Len(); i++ {
+		dep := pack.Depends.At(i).(*godata.GoPackage);
+		depCompile := buildGraph(dep, seen, visiting);
+		compileAction.addDep(packActionFor(dep, depCompile));
+	}
+
+	visiting[pack] = false, false;
+	seen[pack] = compileAction;
+	return compileAction;
+}
+
+func packActionFor(pack *godata.GoPackage, compileAction *action) *action {
+	if a, ok := packActions[pack]; ok {
+		return a;
+	}
+	a := newAction(actionPack, pack);
+	a.addDep(compileAction);
+	packActions[pack] = a;
+	return a;
+}
+
+/*
+ runGraph schedules every leaf action (no remaining dependencies) onto a
+ worker pool sized by numJobs() and blocks until target, and everything
+ it depends on, has run or been skipped.
+*/
+func runGraph(target *action) {
+	scheduleReady = make(chan *action, 1024);
+
+	var all []*action;
+	visited := map[*action]bool{};
+	var collect func(a *action);
+	collect = func(a *action) {
+		if visited[a] {
+			return;
+		}
+		visited[a] = true;
+		for _, dep := range a.deps {
+			collect(dep);
+		}
+		all = append(all, a);
+	};
+	collect(target);
+
+	var wg sync.WaitGroup;
+	for i := 0; i < numJobs(); i++ {
+		wg.Add(1);
+		go func() {
+			defer wg.Done();
+			for a := range scheduleReady {
+				a.run();
+			}
+		}();
+	}
+
+	for _, a := range all {
+		a.mu.Lock();
+		ready := a.remaining == 0;
+		a.mu.Unlock();
+		if ready {
+			scheduleReady <- a;
+		}
+	}
+
+	<-target.done;
+	close(scheduleReady);
+	wg.Wait();
+}
+
+/*
+ buildMain builds the full action graph for one main package (all of its
+ transitive dependencies plus its own compile and link actions) and runs
+ it to completion.
+*/
+func buildMain(mainPack *godata.GoPackage) {
+	compileAction := buildGraph(mainPack, map[*godata.GoPackage]*action{}, map[*godata.GoPackage]bool{});
+	linkAction := newAction(actionLink, mainPack);
+	linkAction.addDep(compileAction);
+	runGraph(linkAction);
+}
+
+/*
+ buildPackage builds the action graph for a single library package (used
+ by -lib) and runs it to completion.
+*/
+func buildPackage(pack *godata.GoPackage) {
+	compileAction := buildGraph(pack, map[*godata.GoPackage]*action{}, map[*godata.GoPackage]bool{});
+	runGraph(packActionFor(pack, compileAction));
+}
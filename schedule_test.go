@@ -0,0 +1,57 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing";
+	"./godata";
+)
+
+func TestActionRunPropagatesCompileFailure(t *testing.T) {
+	pack := &godata.GoPackage{Name: "broken", Compiled: true, HasErrors: true};
+	a := newAction(actionCompile, pack);
+	a.run();
+
+	if !a.Failed {
+		t.Error("a compile action for a package with HasErrors should come out Failed");
+	}
+	if a.Skipped {
+		t.Error("a compile action that actually ran shouldn't be marked Skipped");
+	}
+}
+
+func TestActionRunSkipsWhenDependencyFailed(t *testing.T) {
+	failedCompile := newAction(actionCompile, &godata.GoPackage{Name: "broken", Compiled: true, HasErrors: true});
+	failedCompile.run();
+
+	link := newAction(actionLink, &godata.GoPackage{Name: "main", OutputFile: "app"});
+	link.addDep(failedCompile);
+	link.run();
+
+	if !link.Skipped {
+		t.Error("an action depending on a failed one should be Skipped rather than run");
+	}
+	if link.Failed {
+		t.Error("a Skipped action shouldn't also report Failed");
+	}
+}
+
+func TestAddDepOnFinishedActionDoesNotWait(t *testing.T) {
+	dep := newAction(actionCompile, &godata.GoPackage{Name: "dep", Compiled: true});
+	dep.run();
+
+	parent := newAction(actionPack, &godata.GoPackage{Name: "dep"});
+	parent.addDep(dep);
+
+	if parent.remaining != 0 {
+		t.Error("a dependency that already finished shouldn't be counted against remaining");
+	}
+}
+
+func TestActionRunIsIdempotent(t *testing.T) {
+	dep := newAction(actionCompile, &godata.GoPackage{Name: "dep", Compiled: true});
+	dep.run();
+	dep.run(); // must not panic re-closing dep.done or re-running the work
+}
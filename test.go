@@ -0,0 +1,183 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ test.go implements `gobuild -t`: for every package that has _test.go
+ files, generate a synthetic _testmain.go that imports the package and
+ calls testing.Main with its discovered Test* functions, build it through
+ the normal compile()/link() path, then run the resulting binary.
+*/
+package main
+
+import (
+	os "os";
+	"exec";
+	"fmt";
+	"./godata";
+	"./logger";
+)
+
+/*
+ buildTests walks every known library package and every discovered main
+ package, and for each one that has test files, generates and builds its
+ test binary and runs it. Main packages are included because tests
+ commonly live right alongside package main (a single main.go plus
+ main_test.go is the usual layout for a small program), not only inside
+ library packages gobuild -lib would build. It reports whether any
+ package's tests failed, so main() can exit non-zero the same way
+ `go test` does.
+*/
+func buildTests() bool {
+	ran := false;
+	failed := false;
+
+	test := func(pack *godata.GoPackage, id string) {
+		testFuncs := collectTestFuncs(pack);
+		if len(testFuncs) == 0 {
+			return;
+		}
+		ran = true;
+
+		logger.Info("Testing %s...\n", id);
+		if !runPackageTests(pack, id, testFuncs) {
+			failed = true;
+		}
+	};
+
+	for _, name := range goPackages.GetPackageNames() {
+		pack, _ := goPackages.Get(name);
+		test(pack, pack.Name);
+	}
+	for _, pack := range goPackages.GetMainPackages(false) {
+		test(pack, pack.OutputFile);
+	}
+
+	if !ran {
+		logger.Warn("No tests found.\n");
+	}
+
+	return failed;
+}
+
+// collectTestFuncs gathers the TestFuncs recorded by ParseFile on every
+// _test.go file belonging to pack.
+func collectTestFuncs(pack *godata.GoPackage) []string {
+	var names []string;
+	for i := 0; i < pack.Files.Len(); i++ {
+		gf := pack.Files.At(i).(*godata.GoFile);
+		if gf.IsTest {
+			names = append(names, gf.TestFuncs...);
+		}
+	}
+	return names;
+}
+
+/*
+ runPackageTests generates a _testmain.go for pack, builds it (through
+ the same action graph used for every other main, so pack itself is
+ compiled/packed as an ordinary dependency) into <id>.test, then runs
+ that binary and streams its output. id is pack.Name for an ordinary
+ package but pack.OutputFile for a main package (see buildTests); it
+ names the generated files and is also the import path the generated
+ source uses to reach pack, so it has to match whatever packLib actually
+ archived pack under.
+
+ The generated source is written to a dotfile so that if -t is
+ interrupted, or just on general principle, a later plain gobuild won't
+ walk it back in via readFiles() and register it as a spurious main; it
+ is removed once this function returns either way.
+
+ It returns false if the build or the tests themselves failed.
+*/
+func runPackageTests(pack *godata.GoPackage, id string, testFuncs []string) bool {
+	testMainPath := outputDirPrefix + "." + id + "_testmain.go";
+	source := generateTestMain(id, testFuncs);
+	if err := writeFile(testMainPath, source); err != nil {
+		logger.Error("Could not write %s: %s\n", testMainPath, err);
+		return false;
+	}
+	defer os.Remove(testMainPath);
+
+	testPack := godata.NewMainPackage(id + ".test");
+	testPack.Files.Push(&godata.GoFile{Filename: testMainPath});
+	testPack.Depends.Push(pack);
+
+	buildMain(testPack);
+
+	if testPack.HasErrors {
+		logger.Error("Skipping %s: build failed.\n", id);
+		return false;
+	}
+
+	return runTestBinary(outputDirPrefix + testPack.OutputFile);
+}
+
+// generateTestMain produces the source of a synthetic package main that
+// imports the package under test as "./importName" (via the same local
+// import convention gobuild already understands) and calls testing.Main
+// with every discovered Test* function.
+func generateTestMain(importName string, testFuncs []string) string {
+	entries := "";
+	for _, name := range testFuncs {
+		entries += fmt.Sprintf("\t{\"%s\", target.%s},\n", name, name);
+	}
+
+	return fmt.Sprintf(
+		"// generated by gobuild -t. DO NOT EDIT.\n"+
+		"package main\n\n"+
+		"import (\n"+
+		"\t\"testing\";\n"+
+		"\ttarget \"./%s\";\n"+
+		")\n\n"+
+		"var tests = []testing.InternalTest{\n%s}\n\n"+
+		"func main() {\n"+
+		"\ttesting.Main(testing.MatchString, tests, nil, nil);\n"+
+		"}\n",
+		importName, entries);
+}
+
+func writeFile(path, contents string) os.Error {
+	f, err := os.Open(path, os.O_WRONLY|os.O_CREAT|os.O_TRUNC, 0644);
+	if err != nil {
+		return err;
+	}
+	defer f.Close();
+	_, err = f.WriteString(contents);
+	return err;
+}
+
+// runTestBinary executes a built test binary, passing -run through as
+// -test.run, and streams its output; a non-zero exit is reported as a
+// test failure the same way `go test` reports one. It returns whether
+// the binary ran and passed.
+func runTestBinary(binPath string) bool {
+	argv := []string{binPath};
+	if *flagRun != "" {
+		argv = append(argv, "-test.run", *flagRun);
+	}
+	if *flagVerboseMode {
+		argv = append(argv, "-test.v");
+	}
+
+	cmd, err := exec.Run(binPath, argv, os.Environ(),
+		exec.DevNull, exec.PassThrough, exec.PassThrough);
+	if err != nil {
+		logger.Error("%s\n", err);
+		return false;
+	}
+
+	waitmsg, err := cmd.Wait(0);
+	if err != nil {
+		logger.Error("Test execution error (%s).\n", err);
+		return false;
+	}
+
+	if waitmsg.ExitStatus() != 0 {
+		logger.Error("FAIL\t%s\n", binPath);
+		return false;
+	}
+
+	logger.Info("ok\t%s\n", binPath);
+	return true;
+}
@@ -0,0 +1,198 @@
+// Copyright 2009 by Maurice Gilden. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+ toolchain.go abstracts over the actual compiler/linker/archiver gobuild
+ shells out to, so it isn't hard-wired to the gc toolchain (5g/6g/8g).
+ Select one with -compiler=gc|gccgo or $GOBUILD_COMPILER.
+*/
+package main
+
+import (
+	os "os";
+	"exec";
+	"flag";
+	"strings";
+	"./godata";
+)
+
+var flagCompiler *string = flag.String("compiler", "", "compiler toolchain: gc or gccgo (default $GOBUILD_COMPILER or gc)");
+
+// Toolchain is the set of operations gobuild needs from a Go compiler
+// suite: compiling a package's sources to an object, archiving an object
+// into a library, and linking a main's object into an executable.
+type Toolchain interface {
+	Compile(pack *godata.GoPackage, out string, incl []string) os.Error;
+	Pack(pack *godata.GoPackage, archive, object string) os.Error;
+	Link(pack *godata.GoPackage, out string, libs []string) os.Error;
+	ObjExt() string;
+
+	// CompilerPath returns the path to the compiler binary in use, so the
+	// build cache can fold its path and mtime into a package's build ID.
+	CompilerPath() string;
+}
+
+var toolchain Toolchain;
+
+func selectedCompiler() string {
+	if *flagCompiler != "" {
+		return *flagCompiler;
+	}
+	if env := os.Getenv("GOBUILD_COMPILER"); env != "" {
+		return env;
+	}
+	return "gc";
+}
+
+// newToolchain picks and initializes a Toolchain according to
+// selectedCompiler(), looking up its binaries on $PATH.
+func newToolchain() (Toolchain, os.Error) {
+	switch selectedCompiler() {
+	case "gc":
+		return newGcToolchain();
+	case "gccgo":
+		return newGccgoToolchain();
+	}
+	return nil, os.NewError("unknown -compiler \"" + selectedCompiler() + "\", want gc or gccgo");
+}
+
+// runTool runs argv[0] with argv and turns a non-zero exit status (or a
+// failure to even launch) into an os.Error, the same way every toolchain
+// implementation reports a failed step.
+func runTool(bin string, argv []string) os.Error {
+	cmd, err := exec.Run(bin, argv, os.Environ(),
+		exec.DevNull, exec.PassThrough, exec.PassThrough);
+	if err != nil {
+		return err;
+	}
+	waitmsg, err := cmd.Wait(0);
+	if err != nil {
+		return err;
+	}
+	if waitmsg.ExitStatus() != 0 {
+		return os.NewError(bin + " returned with errors");
+	}
+	return nil;
+}
+
+// ========== gc toolchain (5g/6g/8g + 5l/6l/8l + gopack) ==========
+
+type gcToolchain struct {
+	compilerBin string;
+	linkerBin string;
+	gopackBin string;
+	objExt string;
+}
+
+func newGcToolchain() (Toolchain, os.Error) {
+	t := &gcToolchain{};
+
+	var compilerName, linkerName string;
+	switch os.Getenv("GOARCH") {
+	case "amd64":
+		compilerName, linkerName, t.objExt = "6g", "6l", ".6";
+	case "386":
+		compilerName, linkerName, t.objExt = "8g", "8l", ".8";
+	case "arm":
+		compilerName, linkerName, t.objExt = "5g", "5l", ".5";
+	default:
+		return nil, os.NewError("Please specify a valid GOARCH (amd64/386/arm).");
+	}
+
+	var err os.Error;
+	if t.compilerBin, err = exec.LookPath(compilerName); err != nil {
+		return nil, err;
+	}
+	if t.linkerBin, err = exec.LookPath(linkerName); err != nil {
+		return nil, err;
+	}
+	if t.gopackBin, err = exec.LookPath("gopack"); err != nil {
+		return nil, err;
+	}
+	return t, nil;
+}
+
+func (t *gcToolchain) ObjExt() string {
+	return t.objExt;
+}
+
+func (t *gcToolchain) CompilerPath() string {
+	return t.compilerBin;
+}
+
+func (t *gcToolchain) Compile(pack *godata.GoPackage, out string, incl []string) os.Error {
+	argv := []string{t.compilerBin, "-o", out};
+	if len(incl) > 0 {
+		argv = append(argv, "-I", strings.Join(incl, ":"));
+	}
+	for i := 0; i < pack.Files.Len(); i++ {
+		argv = append(argv, pack.Files.At(i).(*godata.GoFile).Filename);
+	}
+	return runTool(t.compilerBin, argv);
+}
+
+func (t *gcToolchain) Pack(pack *godata.GoPackage, archive, object string) os.Error {
+	argv := []string{t.gopackBin, "crg", archive, object};
+	return runTool(t.gopackBin, argv);
+}
+
+func (t *gcToolchain) Link(pack *godata.GoPackage, out string, libs []string) os.Error {
+	argv := []string{t.linkerBin, "-o", out};
+	if *flagIncludePaths != "" {
+		argv = append(argv, "-L", *flagIncludePaths);
+	}
+	argv = append(argv, libs...);
+	return runTool(t.linkerBin, argv);
+}
+
+// ========== gccgo toolchain ==========
+
+type gccgoToolchain struct {
+	gccgoBin string;
+	arBin string;
+}
+
+func newGccgoToolchain() (Toolchain, os.Error) {
+	t := &gccgoToolchain{};
+
+	var err os.Error;
+	if t.gccgoBin, err = exec.LookPath("gccgo"); err != nil {
+		return nil, err;
+	}
+	if t.arBin, err = exec.LookPath("ar"); err != nil {
+		return nil, err;
+	}
+	return t, nil;
+}
+
+func (t *gccgoToolchain) ObjExt() string {
+	return ".o";
+}
+
+func (t *gccgoToolchain) CompilerPath() string {
+	return t.gccgoBin;
+}
+
+func (t *gccgoToolchain) Compile(pack *godata.GoPackage, out string, incl []string) os.Error {
+	argv := []string{t.gccgoBin, "-c", "-o", out};
+	for _, dir := range incl {
+		argv = append(argv, "-I", dir);
+	}
+	for i := 0; i < pack.Files.Len(); i++ {
+		argv = append(argv, pack.Files.At(i).(*godata.GoFile).Filename);
+	}
+	return runTool(t.gccgoBin, argv);
+}
+
+func (t *gccgoToolchain) Pack(pack *godata.GoPackage, archive, object string) os.Error {
+	argv := []string{t.arBin, "rc", archive, object};
+	return runTool(t.arBin, argv);
+}
+
+func (t *gccgoToolchain) Link(pack *godata.GoPackage, out string, libs []string) os.Error {
+	argv := []string{t.gccgoBin, "-o", out, "-Wl,-("};
+	argv = append(argv, libs...);
+	argv = append(argv, "-Wl,-)");
+	return runTool(t.gccgoBin, argv);
+}